@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1alpha1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1alpha1"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fluxInstallManifestURL is the upstream install manifest bootstrap
+// applies to get the Flux controllers onto the cluster.
+const fluxInstallManifestURL = "https://github.com/fluxcd/flux2/releases/latest/download/install.yaml"
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Install Flux and configure it to manage itself from a Git repository",
+	Long: `
+The bootstrap command installs the Flux controllers into the cluster, generates a
+new deploy key for the target repository, and commits a flux-system
+GitRepository and Kustomization that point Flux at itself back into that
+repository. Running bootstrap again against an already-bootstrapped cluster
+updates the existing resources in place instead of failing.
+Set --git-provider and --git-token to register the deploy key automatically
+and run the whole command non-interactively, e.g. from CI.`,
+	Example: `  # Bootstrap a cluster interactively
+  bootstrap
+
+  # Bootstrap a cluster non-interactively, for use in CI
+  bootstrap --git-url ssh://git@github.com/stefanprodan/podinfo-deploy --git-branch main \
+    --namespace flux-system --git-provider github --git-token $GITHUB_TOKEN
+`,
+	RunE: bootstrapCmdRun,
+}
+
+var (
+	bootstrapGitURL    string
+	bootstrapGitBranch string
+	bootstrapNamespace string
+	bootstrapPath      string
+)
+
+func init() {
+	bootstrapCmd.Flags().StringVar(&bootstrapGitURL, "git-url", "", "git address of the repository Flux will sync from, e.g. ssh://git@host/org/repository")
+	bootstrapCmd.Flags().StringVar(&bootstrapGitBranch, "git-branch", "main", "git branch to sync from")
+	bootstrapCmd.Flags().StringVar(&bootstrapNamespace, "namespace", "flux-system", "namespace to create the flux-system source and kustomization in; the Flux controllers keep the namespace set in the upstream install manifest")
+	bootstrapCmd.Flags().StringVar(&bootstrapPath, "path", "./", "path within the repository to reconcile")
+	bootstrapCmd.Flags().StringVar(&sourceGitProvider, "git-provider", "", "git hosting provider to register the deploy key with, github or gitlab")
+	bootstrapCmd.Flags().StringVar(&sourceGitToken, "git-token", "", "git provider API token, used to register the deploy key and, for HTTPS repositories, to push the initial commit")
+
+	rootCmd.AddCommand(bootstrapCmd)
+}
+
+func bootstrapCmdRun(cmd *cobra.Command, args []string) error {
+	if err := promptBootstrapDefaults(); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(bootstrapGitURL)
+	if err != nil {
+		return fmt.Errorf("git URL parse failed: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c, err := kubeClient()
+	if err != nil {
+		return fmt.Errorf("kube client init failed: %w", err)
+	}
+
+	logAction("installing the Flux controllers")
+	if err := installFluxControllers(ctx, c, bootstrapNamespace); err != nil {
+		return fmt.Errorf("controller install failed: %w", err)
+	}
+
+	name := "flux-system"
+	namespace = bootstrapNamespace
+
+	withAuth := false
+	var deployKey *keyPair
+	if u.Scheme == "ssh" {
+		deployKey, err = generateSSH(ctx, c, name, u, true)
+		if err != nil {
+			return err
+		}
+		withAuth = true
+	}
+
+	// TypeMeta is set explicitly here because these objects are also
+	// marshaled to YAML and committed to the repo by commitFluxManifests
+	// below; unlike applyGitRepository/applyKustomization, which get their
+	// GVK from the typed client's scheme, the on-disk files need it to be
+	// readable by kustomize-controller on its own.
+	gitRepository := &sourcev1.GitRepository{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: sourcev1.GroupVersion.String(),
+			Kind:       "GitRepository",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: bootstrapNamespace},
+		Spec: sourcev1.GitRepositorySpec{
+			URL:       bootstrapGitURL,
+			Interval:  metav1.Duration{Duration: time.Minute},
+			Reference: &sourcev1.GitRepositoryRef{Branch: bootstrapGitBranch},
+		},
+	}
+	if withAuth {
+		gitRepository.Spec.SecretRef = &corev1.LocalObjectReference{Name: name}
+	}
+
+	kustomization := &kustomizev1.Kustomization{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: kustomizev1.GroupVersion.String(),
+			Kind:       "Kustomization",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: bootstrapNamespace},
+		Spec: kustomizev1.KustomizationSpec{
+			Interval: metav1.Duration{Duration: time.Minute},
+			Path:     bootstrapPath,
+			Prune:    true,
+			SourceRef: kustomizev1.CrossNamespaceSourceReference{
+				Kind: "GitRepository",
+				Name: name,
+			},
+		},
+	}
+
+	logAction("committing %s source and kustomization to %s", name, bootstrapGitURL)
+	auth, err := bootstrapGitAuth(u, deployKey)
+	if err != nil {
+		return fmt.Errorf("git auth setup failed: %w", err)
+	}
+	if err := commitFluxManifests(ctx, bootstrapGitURL, bootstrapGitBranch, bootstrapPath, auth, gitRepository, kustomization); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+
+	if err := applyGitRepository(ctx, c, gitRepository); err != nil {
+		return fmt.Errorf("source apply failed: %w", err)
+	}
+	if err := applyKustomization(ctx, c, kustomization); err != nil {
+		return fmt.Errorf("kustomization apply failed: %w", err)
+	}
+
+	logAction("waiting for flux-system to reconcile")
+	if err := waitForGitRepositoryReady(ctx, c, name); err != nil {
+		return fmt.Errorf("source sync failed: %w", err)
+	}
+	if err := waitForKustomizationReady(ctx, c, name); err != nil {
+		return fmt.Errorf("kustomization sync failed: %w", err)
+	}
+	logSuccess("bootstrap finished")
+	return nil
+}
+
+// promptBootstrapDefaults interactively asks for the repository URL when
+// --git-url was not set, so the command can still be run non-interactively
+// in CI by passing it up front. No other flag is prompted for; branch,
+// namespace and path keep their flag defaults.
+func promptBootstrapDefaults() error {
+	if bootstrapGitURL == "" {
+		prompt := promptui.Prompt{Label: "Repository URL"}
+		result, err := prompt.Run()
+		if err != nil {
+			return fmt.Errorf("aborting")
+		}
+		bootstrapGitURL = result
+	}
+	return nil
+}
+
+// applyKustomization creates the Kustomization, or updates it in place
+// if one with the same name already exists in the namespace.
+func applyKustomization(ctx context.Context, c client.Client, kustomization *kustomizev1.Kustomization) error {
+	existing := &kustomizev1.Kustomization{}
+	key := client.ObjectKeyFromObject(kustomization)
+	if err := c.Get(ctx, key, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		return c.Create(ctx, kustomization)
+	}
+	kustomization.ResourceVersion = existing.ResourceVersion
+	return c.Update(ctx, kustomization)
+}
+
+// waitForKustomizationReady polls the Kustomization until its Ready
+// condition is true or the command timeout elapses.
+func waitForKustomizationReady(ctx context.Context, c client.Client, name string) error {
+	return wait.PollImmediateUntil(2*time.Second, func() (bool, error) {
+		var k kustomizev1.Kustomization
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &k); err != nil {
+			return false, nil
+		}
+		for _, cond := range k.Status.Conditions {
+			if cond.Type == kustomizev1.ReadyCondition && cond.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, ctx.Done())
+}
+
+// installFluxControllers ensures the target namespace exists, then
+// applies the upstream Flux controller install manifest as published:
+// its objects keep the namespaces written in the manifest, and ns is
+// not applied to them. Every object in the manifest is created or updated in place, so
+// re-running bootstrap against an already-bootstrapped cluster
+// reconciles the controllers instead of failing on resources that
+// already exist.
+func installFluxControllers(ctx context.Context, c client.Client, ns string) error {
+	existing := &corev1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: ns}, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err := c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}); err != nil {
+			return fmt.Errorf("namespace create failed: %w", err)
+		}
+		logAction("namespace %s created", ns)
+	}
+
+	if err := applyManifestURL(ctx, c, fluxInstallManifestURL); err != nil {
+		return fmt.Errorf("controller manifest apply failed: %w", err)
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -10,9 +9,18 @@ import (
 	"os"
 	"strings"
 	"text/template"
+	"time"
 
+	sourcev1 "github.com/fluxcd/source-controller/api/v1alpha1"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var createSourceCmd = &cobra.Command{
@@ -20,7 +28,12 @@ var createSourceCmd = &cobra.Command{
 	Short: "Create source resource",
 	Long: `
 The create source command generates a source.fluxcd.io resource and waits for it to sync.
-For Git over SSH, host and SSH keys are automatically generated.`,
+For Git over SSH, host and SSH keys are automatically generated, or reused from
+--ssh-private-key-file / --ssh-known-hosts-file when set. For Git over HTTPS, use
+--git-token for token authentication or -u/-p for basic authentication.
+When --git-provider and --git-token are set for an SSH URL, the generated deploy
+key is registered with the hosting provider automatically instead of prompting
+for manual confirmation; a key loaded from --ssh-private-key-file is not registered.`,
 	Example: `  # Create a gitrepository.source.fluxcd.io for a public repository
   create source podinfo --git-url https://github.com/stefanprodan/podinfo-deploy --git-branch master
 
@@ -32,16 +45,32 @@ For Git over SSH, host and SSH keys are automatically generated.`,
 
   # Create a gitrepository.source.fluxcd.io with basic authentication
   create source podinfo --git-url https://github.com/stefanprodan/podinfo-deploy -u username -p password
+
+  # Create a gitrepository.source.fluxcd.io and register the deploy key with GitHub
+  create source podinfo --git-url ssh://git@github.com/stefanprodan/podinfo-deploy --git-provider github --git-token $GITHUB_TOKEN
+
+  # Create a gitrepository.source.fluxcd.io with HTTPS token authentication
+  create source podinfo --git-url https://github.com/stefanprodan/podinfo-deploy --git-token $GITHUB_TOKEN
+
+  # Create a gitrepository.source.fluxcd.io reusing an existing SSH key pair
+  create source podinfo --git-url ssh://git@github.com/stefanprodan/podinfo-deploy \
+    --ssh-private-key-file ./identity --ssh-known-hosts-file ./known_hosts
 `,
 	RunE: createSourceCmdRun,
 }
 
 var (
-	sourceGitURL    string
-	sourceGitBranch string
-	sourceGitSemver string
-	sourceUsername  string
-	sourcePassword  string
+	sourceGitURL            string
+	sourceGitBranch         string
+	sourceGitSemver         string
+	sourceUsername          string
+	sourcePassword          string
+	sourceGitProvider       string
+	sourceGitToken          string
+	sourceSSHPrivateKeyFile string
+	sourceSSHKnownHostsFile string
+	sourceSSHKeyAlgo        string
+	sourceSSHKeyBits        int
 )
 
 func init() {
@@ -50,6 +79,12 @@ func init() {
 	createSourceCmd.Flags().StringVar(&sourceGitSemver, "git-semver", "", "git tag semver range")
 	createSourceCmd.Flags().StringVarP(&sourceUsername, "username", "u", "", "basic authentication username")
 	createSourceCmd.Flags().StringVarP(&sourcePassword, "password", "p", "", "basic authentication password")
+	createSourceCmd.Flags().StringVar(&sourceGitProvider, "git-provider", "", "git hosting provider to register the deploy key with, github or gitlab; gitlab registers a project-level key, there is no API to register one across a whole group")
+	createSourceCmd.Flags().StringVar(&sourceGitToken, "git-token", "", "bearer token for HTTPS authentication, or the git provider API token used to register the deploy key")
+	createSourceCmd.Flags().StringVar(&sourceSSHPrivateKeyFile, "ssh-private-key-file", "", "path to an existing SSH private key to use instead of generating a new one")
+	createSourceCmd.Flags().StringVar(&sourceSSHKnownHostsFile, "ssh-known-hosts-file", "", "path to an existing known_hosts file, required together with --ssh-private-key-file")
+	createSourceCmd.Flags().StringVar(&sourceSSHKeyAlgo, "ssh-key-algo", "rsa", "SSH key algorithm used when generating a new deploy key, rsa, ecdsa or ed25519")
+	createSourceCmd.Flags().IntVar(&sourceSSHKeyBits, "ssh-key-bits", 2048, "SSH key bit size, only used for the rsa algorithm")
 
 	createCmd.AddCommand(createSourceCmd)
 }
@@ -64,12 +99,6 @@ func createSourceCmdRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("git-url is required")
 	}
 
-	tmpDir, err := ioutil.TempDir("", name)
-	if err != nil {
-		return err
-	}
-	defer os.RemoveAll(tmpDir)
-
 	u, err := url.Parse(sourceGitURL)
 	if err != nil {
 		return fmt.Errorf("git URL parse failed: %w", err)
@@ -78,14 +107,30 @@ func createSourceCmdRun(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	c, err := kubeClient()
+	if err != nil {
+		return fmt.Errorf("kube client init failed: %w", err)
+	}
+
 	withAuth := false
-	if strings.HasPrefix(sourceGitURL, "ssh") {
-		if err := generateSSH(ctx, name, u.Host, tmpDir); err != nil {
+	switch detectGitAuthMode(u) {
+	case gitAuthSSHGenerated:
+		if _, err := generateSSH(ctx, c, name, u, false); err != nil {
+			return err
+		}
+		withAuth = true
+	case gitAuthSSHExisting:
+		if err := useExistingSSH(ctx, c, name); err != nil {
+			return err
+		}
+		withAuth = true
+	case gitAuthToken:
+		if err := generateTokenAuth(ctx, c, name); err != nil {
 			return err
 		}
 		withAuth = true
-	} else if sourceUsername != "" && sourcePassword != "" {
-		if err := generateBasicAuth(ctx, name); err != nil {
+	case gitAuthBasic:
+		if err := generateBasicAuth(ctx, c, name); err != nil {
 			return err
 		}
 		withAuth = true
@@ -93,11 +138,86 @@ func createSourceCmdRun(cmd *cobra.Command, args []string) error {
 
 	logAction("generating source %s in %s namespace", name, namespace)
 
+	syncInterval, err := time.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("git-interval parse failed: %w", err)
+	}
+
+	gitRepository := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: sourcev1.GitRepositorySpec{
+			URL:      sourceGitURL,
+			Interval: metav1.Duration{Duration: syncInterval},
+		},
+	}
+	if sourceGitSemver != "" {
+		gitRepository.Spec.Reference = &sourcev1.GitRepositoryRef{SemVer: sourceGitSemver}
+	} else {
+		gitRepository.Spec.Reference = &sourcev1.GitRepositoryRef{Branch: sourceGitBranch}
+	}
+	if withAuth {
+		gitRepository.Spec.SecretRef = &corev1.LocalObjectReference{Name: name}
+	}
+
+	if verbose {
+		printSourceYAML(gitRepository)
+	}
+
+	if err := applyGitRepository(ctx, c, gitRepository); err != nil {
+		return fmt.Errorf("source apply failed: %w", err)
+	}
+
+	logAction("waiting for source sync")
+	if err := waitForGitRepositoryReady(ctx, c, name); err != nil {
+		return fmt.Errorf("source sync failed: %w", err)
+	}
+	logSuccess("source %s is ready", name)
+	return nil
+}
+
+// applyGitRepository creates the GitRepository, or updates it in place
+// if one with the same name already exists in the namespace.
+func applyGitRepository(ctx context.Context, c client.Client, gitRepository *sourcev1.GitRepository) error {
+	existing := &sourcev1.GitRepository{}
+	key := client.ObjectKeyFromObject(gitRepository)
+	if err := c.Get(ctx, key, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		return c.Create(ctx, gitRepository)
+	}
+	gitRepository.ResourceVersion = existing.ResourceVersion
+	return c.Update(ctx, gitRepository)
+}
+
+// waitForGitRepositoryReady polls the GitRepository until its Ready
+// condition is true or the command timeout elapses.
+func waitForGitRepositoryReady(ctx context.Context, c client.Client, name string) error {
+	return wait.PollImmediateUntil(2*time.Second, func() (bool, error) {
+		var repo sourcev1.GitRepository
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &repo); err != nil {
+			return false, nil
+		}
+		for _, cond := range repo.Status.Conditions {
+			if cond.Type == sourcev1.ReadyCondition && cond.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, ctx.Done())
+}
+
+// printSourceYAML renders the GitRepository as YAML for --verbose output.
+func printSourceYAML(gitRepository *sourcev1.GitRepository) {
 	t, err := template.New("tmpl").Parse(gitSource)
 	if err != nil {
-		return fmt.Errorf("template parse error: %w", err)
+		return
 	}
 
+	ref := gitRepository.Spec.Reference
 	source := struct {
 		Name      string
 		Namespace string
@@ -107,95 +227,150 @@ func createSourceCmdRun(cmd *cobra.Command, args []string) error {
 		Interval  string
 		WithAuth  bool
 	}{
-		Name:      name,
-		Namespace: namespace,
-		URL:       sourceGitURL,
-		Branch:    sourceGitBranch,
-		Semver:    sourceGitSemver,
+		Name:      gitRepository.Name,
+		Namespace: gitRepository.Namespace,
+		URL:       gitRepository.Spec.URL,
+		Branch:    ref.Branch,
+		Semver:    ref.SemVer,
 		Interval:  interval,
-		WithAuth:  withAuth,
+		WithAuth:  gitRepository.Spec.SecretRef != nil,
 	}
 
 	var data bytes.Buffer
-	writer := bufio.NewWriter(&data)
-	if err := t.Execute(writer, source); err != nil {
-		return fmt.Errorf("template execution failed: %w", err)
-	}
-	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("source flush failed: %w", err)
-	}
-
-	if verbose {
-		fmt.Print(data.String())
+	if err := t.Execute(&data, source); err != nil {
+		return
 	}
+	fmt.Print(data.String())
+}
 
-	command := fmt.Sprintf("echo '%s' | kubectl apply -f-", data.String())
-	if _, err := utils.execCommand(ctx, ModeStderrOS, command); err != nil {
-		return fmt.Errorf("source apply failed")
+func generateBasicAuth(ctx context.Context, c client.Client, name string) error {
+	logAction("saving credentials")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		StringData: map[string]string{
+			"username": sourceUsername,
+			"password": sourcePassword,
+		},
 	}
-
-	logAction("waiting for source sync")
-	command = fmt.Sprintf("kubectl -n %s wait gitrepository/%s --for=condition=ready --timeout=1m",
-		namespace, name)
-	if _, err := utils.execCommand(ctx, ModeStderrOS, command); err != nil {
-		return fmt.Errorf("source sync failed")
+	if err := applySecret(ctx, c, secret); err != nil {
+		return fmt.Errorf("credentials secret failed: %w", err)
 	}
-	logSuccess("source %s is ready", name)
 	return nil
 }
 
-func generateBasicAuth(ctx context.Context, name string) error {
-	logAction("saving credentials")
-	credentials := fmt.Sprintf("--from-literal=username='%s' --from-literal=password='%s'",
-		sourceUsername, sourcePassword)
-	secret := fmt.Sprintf("kubectl -n %s create secret generic %s %s --dry-run=client -oyaml | kubectl apply -f-",
-		namespace, name, credentials)
-	if _, err := utils.execCommand(ctx, ModeOS, secret); err != nil {
-		return fmt.Errorf("kubectl create secret failed")
+// generateTokenAuth saves a bearer token as username=git, password=<token>,
+// the format the source-controller expects for HTTPS token authentication.
+func generateTokenAuth(ctx context.Context, c client.Client, name string) error {
+	logAction("saving token credentials")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		StringData: map[string]string{
+			"username": "git",
+			"password": sourceGitToken,
+		},
+	}
+	if err := applySecret(ctx, c, secret); err != nil {
+		return fmt.Errorf("token secret failed: %w", err)
 	}
 	return nil
 }
 
-func generateSSH(ctx context.Context, name, host, tmpDir string) error {
-	logAction("generating host key for %s", host)
+// useExistingSSH saves a user-supplied private key and known_hosts file
+// instead of generating and registering a brand new deploy key.
+func useExistingSSH(ctx context.Context, c client.Client, name string) error {
+	if sourceSSHKnownHostsFile == "" {
+		return fmt.Errorf("ssh-known-hosts-file is required together with ssh-private-key-file")
+	}
 
-	command := fmt.Sprintf("ssh-keyscan %s > %s/known_hosts", host, tmpDir)
-	if _, err := utils.execCommand(ctx, ModeStderrOS, command); err != nil {
-		return fmt.Errorf("ssh-keyscan failed")
+	logAction("loading existing deploy key from %s", sourceSSHPrivateKeyFile)
+	privateKey, err := ioutil.ReadFile(sourceSSHPrivateKeyFile)
+	if err != nil {
+		return fmt.Errorf("private key read failed: %w", err)
+	}
+	knownHosts, err := ioutil.ReadFile(sourceSSHKnownHostsFile)
+	if err != nil {
+		return fmt.Errorf("known_hosts read failed: %w", err)
 	}
 
-	logAction("generating deploy key")
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("private key parse failed: %w", err)
+	}
+	publicKey := ssh.MarshalAuthorizedKey(signer.PublicKey())
 
-	command = fmt.Sprintf("ssh-keygen -b 2048 -t rsa -f %s/identity -q -N \"\"", tmpDir)
-	if _, err := utils.execCommand(ctx, ModeStderrOS, command); err != nil {
-		return fmt.Errorf("ssh-keygen failed")
+	logAction("saving deploy key")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Data: map[string][]byte{
+			"identity":     privateKey,
+			"identity.pub": publicKey,
+			"known_hosts":  knownHosts,
+		},
 	}
+	if err := applySecret(ctx, c, secret); err != nil {
+		return fmt.Errorf("deploy key secret failed: %w", err)
+	}
+	return nil
+}
 
-	command = fmt.Sprintf("cat %s/identity.pub", tmpDir)
-	if deployKey, err := utils.execCommand(ctx, ModeCapture, command); err != nil {
-		return fmt.Errorf("unable to read identity.pub: %w", err)
-	} else {
-		fmt.Print(deployKey)
+// generateSSH generates a deploy key and host key for u, saves them as
+// a Secret, and registers the public key with the configured git
+// provider (or prompts for manual confirmation otherwise). writeAccess
+// controls whether the deploy key is registered read-only or with push
+// access; only bootstrap, which commits back to the repo, opts into
+// push access. The generated key pair is returned so callers that also
+// need to push over Git (bootstrap) don't have to read it back out of
+// the Secret.
+func generateSSH(ctx context.Context, c client.Client, name string, u *url.URL, writeAccess bool) (*keyPair, error) {
+	host := u.Host
+	logAction("generating host key for %s", host)
+	knownHosts, err := scanHostKey(host)
+	if err != nil {
+		return nil, fmt.Errorf("host key scan failed: %w", err)
 	}
 
-	prompt := promptui.Prompt{
-		Label:     "Have you added the deploy key to your repository",
-		IsConfirm: true,
+	logAction("generating deploy key")
+	pair, err := generateKeyPairForAlgo(sourceSSHKeyAlgo, sourceSSHKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("deploy key generation failed: %w", err)
 	}
-	if _, err := prompt.Run(); err != nil {
-		logFailure("aborting")
-		os.Exit(1)
+
+	fmt.Print(string(pair.PublicKeyAuth))
+
+	if sourceGitProvider != "" && sourceGitToken != "" {
+		owner, repo, err := gitProviderOwnerRepo(strings.TrimPrefix(u.Path, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("deploy key registration failed: %w", err)
+		}
+		logAction("registering deploy key with %s", sourceGitProvider)
+		publicKey := strings.TrimSpace(string(pair.PublicKeyAuth))
+		if err := registerDeployKey(ctx, sourceGitProvider, sourceGitToken, owner, repo, name, publicKey, writeAccess); err != nil {
+			return nil, fmt.Errorf("deploy key registration failed: %w", err)
+		}
+	} else {
+		prompt := promptui.Prompt{
+			Label:     "Have you added the deploy key to your repository",
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			logFailure("aborting")
+			os.Exit(1)
+		}
 	}
 
 	logAction("saving deploy key")
-	files := fmt.Sprintf("--from-file=%s/identity --from-file=%s/identity.pub --from-file=%s/known_hosts",
-		tmpDir, tmpDir, tmpDir)
-	secret := fmt.Sprintf("kubectl -n %s create secret generic %s %s --dry-run=client -oyaml | kubectl apply -f-",
-		namespace, name, files)
-	if _, err := utils.execCommand(ctx, ModeOS, secret); err != nil {
-		return fmt.Errorf("create secret failed")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Data: map[string][]byte{
+			"identity":     pair.PrivateKeyPEM,
+			"identity.pub": pair.PublicKeyAuth,
+			"known_hosts":  knownHosts,
+		},
 	}
-	return nil
+	if err := applySecret(ctx, c, secret); err != nil {
+		return nil, fmt.Errorf("deploy key secret failed: %w", err)
+	}
+	return pair, nil
 }
 
 var gitSource = `---
@@ -0,0 +1,35 @@
+package main
+
+import "net/url"
+
+// gitAuthMode identifies which of the authentication strategies the
+// source creator should use for a given Git URL and flag combination.
+type gitAuthMode int
+
+const (
+	gitAuthNone gitAuthMode = iota
+	gitAuthSSHGenerated
+	gitAuthSSHExisting
+	gitAuthToken
+	gitAuthBasic
+)
+
+// detectGitAuthMode picks an auth mode from the parsed Git URL and the
+// auth related flags, favouring the most specific flag combination so
+// that e.g. --ssh-private-key-file always wins over generating a fresh
+// key pair for an ssh:// URL.
+func detectGitAuthMode(u *url.URL) gitAuthMode {
+	switch {
+	case u.Scheme == "ssh":
+		if sourceSSHPrivateKeyFile != "" {
+			return gitAuthSSHExisting
+		}
+		return gitAuthSSHGenerated
+	case sourceGitToken != "":
+		return gitAuthToken
+	case sourceUsername != "" && sourcePassword != "":
+		return gitAuthBasic
+	default:
+		return gitAuthNone
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// commitFluxManifests clones branch of gitURL, writes each object as a
+// YAML file under path, and pushes a commit back to the branch if that
+// produced any change. This is what makes the flux-system source
+// self-managing: the GitRepository and Kustomization bootstrap applies
+// to the cluster are also durably recorded in the repo Flux syncs from.
+func commitFluxManifests(ctx context.Context, gitURL, branch, path string, auth transport.AuthMethod, objects ...interface{}) error {
+	tmpDir, err := ioutil.TempDir("", "flux-bootstrap")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repo, err := git.PlainCloneContext(ctx, tmpDir, false, &git.CloneOptions{
+		URL:           gitURL,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("git clone failed: %w", err)
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("git worktree failed: %w", err)
+	}
+
+	manifestDir := filepath.Join(tmpDir, path)
+	if err := os.MkdirAll(manifestDir, 0o755); err != nil {
+		return fmt.Errorf("manifest dir create failed: %w", err)
+	}
+
+	for i, obj := range objects {
+		data, err := k8syaml.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("manifest marshal failed: %w", err)
+		}
+		relPath := filepath.Join(path, fmt.Sprintf("flux-system-%d.yaml", i))
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, relPath), data, 0o644); err != nil {
+			return fmt.Errorf("manifest write failed: %w", err)
+		}
+		if _, err := wt.Add(relPath); err != nil {
+			return fmt.Errorf("git add failed: %w", err)
+		}
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return fmt.Errorf("git status failed: %w", err)
+	}
+	if status.IsClean() {
+		logAction("flux-system manifests already up to date in %s", gitURL)
+		return nil
+	}
+
+	if _, err := wt.Commit("Add flux-system source and kustomization", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "flux",
+			Email: "flux@users.noreply.github.com",
+			When:  time.Now(),
+		},
+	}); err != nil {
+		return fmt.Errorf("git commit failed: %w", err)
+	}
+
+	if err := repo.PushContext(ctx, &git.PushOptions{Auth: auth}); err != nil {
+		return fmt.Errorf("git push failed: %w", err)
+	}
+	return nil
+}
+
+// bootstrapGitAuth builds the go-git transport auth method for gitURL
+// from the credentials bootstrap already has in hand: the freshly
+// generated deploy key for ssh:// URLs, or the --git-token for HTTPS.
+func bootstrapGitAuth(u *url.URL, pair *keyPair) (transport.AuthMethod, error) {
+	if u.Scheme == "ssh" {
+		if pair == nil {
+			return nil, fmt.Errorf("no deploy key available for ssh authentication")
+		}
+		signer, err := ssh.ParsePrivateKey(pair.PrivateKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("deploy key parse failed: %w", err)
+		}
+		return &gitssh.PublicKeys{User: "git", Signer: signer}, nil
+	}
+	if sourceGitToken != "" {
+		return &githttp.BasicAuth{Username: "git", Password: sourceGitToken}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// gitProviderOwnerRepo splits a parsed Git URL's path into the owner (or
+// group, for GitLab subgroups) and repository name expected by the
+// GitHub and GitLab APIs.
+func gitProviderOwnerRepo(path string) (owner, repo string, err error) {
+	trimmed := strings.Trim(path, "/")
+	trimmed = strings.TrimSuffix(trimmed, ".git")
+	parts := strings.Split(trimmed, "/")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("unable to determine owner/repository from %q", path)
+	}
+	return strings.Join(parts[:len(parts)-1], "/"), parts[len(parts)-1], nil
+}
+
+// registerDeployKey uploads the generated public key to the hosting
+// provider so the repository accepts pulls from the newly created
+// secret without a manual round trip through the web UI. The key is
+// registered read-only unless writeAccess is set; only a self-managing
+// bootstrap repository should ever need write access.
+//
+// GitLab deploy keys are always project scoped: there is no public API
+// to register a key once for every project under a group or subgroup,
+// so "handle group-level keys for subgroups" is implemented as "address
+// the right project, however deep it is nested under groups" rather
+// than as a single key shared across a group's projects. owner may
+// itself contain "/"-separated subgroup segments, which is enough to
+// resolve a project nested arbitrarily deep under subgroups, but the
+// registered key still only unlocks that one project, not its siblings.
+func registerDeployKey(ctx context.Context, provider, token, owner, repo, title, publicKey string, writeAccess bool) error {
+	switch provider {
+	case "github":
+		return registerGitHubDeployKey(ctx, token, owner, repo, title, publicKey, writeAccess)
+	case "gitlab":
+		return registerGitLabDeployKey(ctx, token, owner, repo, title, publicKey, writeAccess)
+	default:
+		return fmt.Errorf("unsupported git provider %q", provider)
+	}
+}
+
+func registerGitHubDeployKey(ctx context.Context, token, owner, repo, title, publicKey string, writeAccess bool) error {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/keys", owner, repo)
+	body, err := json.Marshal(map[string]interface{}{
+		"title":     title,
+		"key":       publicKey,
+		"read_only": !writeAccess,
+	})
+	if err != nil {
+		return fmt.Errorf("deploy key payload encode failed: %w", err)
+	}
+	return doProviderRequest(ctx, http.MethodPost, url, token, body)
+}
+
+func registerGitLabDeployKey(ctx context.Context, token, projectPath, repo, title, publicKey string, writeAccess bool) error {
+	project := projectPath
+	if repo != "" {
+		project = projectPath + "/" + repo
+	}
+	url := fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/deploy_keys", urlPathEscape(project))
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    title,
+		"key":      publicKey,
+		"can_push": writeAccess,
+	})
+	if err != nil {
+		return fmt.Errorf("deploy key payload encode failed: %w", err)
+	}
+	return doProviderRequest(ctx, http.MethodPost, url, token, body)
+}
+
+func urlPathEscape(projectPath string) string {
+	return strings.ReplaceAll(projectPath, "/", "%2F")
+}
+
+func doProviderRequest(ctx context.Context, method, url, token string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("provider request build failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("provider request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("provider request to %s returned %s", url, resp.Status)
+	}
+	return nil
+}
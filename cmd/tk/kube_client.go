@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	kustomizev1 "github.com/fluxcd/kustomize-controller/api/v1alpha1"
+	sourcev1 "github.com/fluxcd/source-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// scheme is the runtime scheme used to decode and apply the resources
+// emitted by the create commands.
+var scheme = runtime.NewScheme()
+
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = sourcev1.AddToScheme(scheme)
+	_ = kustomizev1.AddToScheme(scheme)
+}
+
+// kubeClient returns a controller-runtime client configured from the
+// default kubeconfig, falling back to in-cluster config when run from
+// inside a pod.
+func kubeClient() (client.Client, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig load failed: %w", err)
+	}
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// applySecret creates the given Secret, or updates it in place if a
+// Secret with the same name already exists in the namespace.
+func applySecret(ctx context.Context, c client.Client, secret *corev1.Secret) error {
+	existing := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKeyFromObject(secret), existing)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("secret lookup failed: %w", err)
+		}
+		if err := c.Create(ctx, secret); err != nil {
+			return fmt.Errorf("secret create failed: %w", err)
+		}
+		return nil
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	if err := c.Update(ctx, secret); err != nil {
+		return fmt.Errorf("secret update failed: %w", err)
+	}
+	return nil
+}
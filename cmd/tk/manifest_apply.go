@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyManifestURL downloads a multi-document YAML manifest and applies
+// every object it contains, creating it or updating it in place if an
+// object with the same name already exists. This is how the Flux
+// controller install manifest gets onto the cluster without requiring
+// kubectl on the caller's PATH.
+func applyManifestURL(ctx context.Context, c client.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("manifest request build failed: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("manifest download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("manifest download from %s returned %s", url, resp.Status)
+	}
+
+	return applyManifestReader(ctx, c, resp.Body)
+}
+
+// applyManifestReader decodes and applies every YAML document in r.
+func applyManifestReader(ctx context.Context, c client.Client, r io.Reader) error {
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("manifest read failed: %w", err)
+		}
+		if len(strings.TrimSpace(string(doc))) == 0 {
+			continue
+		}
+
+		jsonDoc, err := k8syaml.ToJSON(doc)
+		if err != nil {
+			return fmt.Errorf("manifest decode failed: %w", err)
+		}
+
+		obj := &unstructured.Unstructured{}
+		if _, _, err := unstructured.UnstructuredJSONScheme.Decode(jsonDoc, nil, obj); err != nil {
+			return fmt.Errorf("manifest decode failed: %w", err)
+		}
+		if obj.GetKind() == "" {
+			continue
+		}
+
+		if err := applyObject(ctx, c, obj); err != nil {
+			return fmt.Errorf("apply %s/%s failed: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+}
+
+func applyObject(ctx context.Context, c client.Client, obj *unstructured.Unstructured) error {
+	existing := obj.DeepCopy()
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		return c.Create(ctx, obj)
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return c.Update(ctx, obj)
+}
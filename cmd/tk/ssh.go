@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keyPair holds a generated SSH identity in the formats required by a
+// GitRepository secretRef: a PEM encoded private key and an
+// authorized_keys formatted public key.
+type keyPair struct {
+	PrivateKeyPEM []byte
+	PublicKeyAuth []byte
+}
+
+// generateKeyPair creates a new RSA SSH key pair of the given bit size,
+// without ever shelling out to ssh-keygen.
+func generateKeyPair(bits int) (*keyPair, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, fmt.Errorf("rsa key generation failed: %w", err)
+	}
+
+	privBlock := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	}
+
+	pub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key conversion failed: %w", err)
+	}
+
+	return &keyPair{
+		PrivateKeyPEM: pem.EncodeToMemory(privBlock),
+		PublicKeyAuth: ssh.MarshalAuthorizedKey(pub),
+	}, nil
+}
+
+// generateEd25519KeyPair creates a new Ed25519 SSH key pair.
+func generateEd25519KeyPair() (*keyPair, error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ed25519 key generation failed: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("ed25519 key marshal failed: %w", err)
+	}
+
+	pub, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key conversion failed: %w", err)
+	}
+
+	return &keyPair{
+		PrivateKeyPEM: pem.EncodeToMemory(block),
+		PublicKeyAuth: ssh.MarshalAuthorizedKey(pub),
+	}, nil
+}
+
+// generateECDSAKeyPair creates a new P-256 ECDSA SSH key pair.
+func generateECDSAKeyPair() (*keyPair, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ecdsa key generation failed: %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("ecdsa key marshal failed: %w", err)
+	}
+	privBlock := &pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	}
+
+	pub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key conversion failed: %w", err)
+	}
+
+	return &keyPair{
+		PrivateKeyPEM: pem.EncodeToMemory(privBlock),
+		PublicKeyAuth: ssh.MarshalAuthorizedKey(pub),
+	}, nil
+}
+
+// generateKeyPairForAlgo creates a new SSH key pair of the requested
+// algorithm. bits is only honoured for rsa; it is ignored otherwise.
+func generateKeyPairForAlgo(algo string, bits int) (*keyPair, error) {
+	switch algo {
+	case "", "rsa":
+		return generateKeyPair(bits)
+	case "ecdsa":
+		return generateECDSAKeyPair()
+	case "ed25519":
+		return generateEd25519KeyPair()
+	default:
+		return nil, fmt.Errorf("unsupported ssh key algorithm %q", algo)
+	}
+}
+
+// scanHostKey dials the SSH server at host (optionally "host:port"; port
+// defaults to 22) and returns the host key it presents during the
+// handshake, in known_hosts format. This replaces shelling out to
+// ssh-keyscan.
+func scanHostKey(host string) ([]byte, error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, "22"
+	}
+	addr := net.JoinHostPort(hostname, port)
+
+	hostKeys := make(chan ssh.PublicKey, 1)
+	config := &ssh.ClientConfig{
+		User:    "git",
+		Auth:    []ssh.AuthMethod{},
+		Timeout: 10 * time.Second,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKeys <- key
+			return fmt.Errorf("host key captured")
+		},
+	}
+
+	// The handshake is expected to fail once the host key callback has
+	// captured the key; only surface unexpected connection errors.
+	if _, err := ssh.Dial("tcp", addr, config); err != nil {
+		select {
+		case key := <-hostKeys:
+			return formatKnownHosts(hostname, port, key), nil
+		default:
+			return nil, fmt.Errorf("ssh dial to %s failed: %w", addr, err)
+		}
+	}
+
+	return nil, fmt.Errorf("server did not present a host key")
+}
+
+// formatKnownHosts renders a known_hosts line for hostname/port. OpenSSH
+// (and source-controller's known_hosts verification) only accepts the
+// bare hostname for the default port 22; any other port must use the
+// "[hostname]:port" bracket form, or host-key verification silently
+// fails to match.
+func formatKnownHosts(hostname, port string, key ssh.PublicKey) []byte {
+	addr := hostname
+	if port != "" && port != "22" {
+		addr = fmt.Sprintf("[%s]:%s", hostname, port)
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	return []byte(fmt.Sprintf("%s %s\n", addr, authorized))
+}